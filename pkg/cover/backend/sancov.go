@@ -0,0 +1,208 @@
+// Copyright 2026 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+)
+
+// SancovPC describes a single entry of a sancov PC-table, as produced by binaries built with
+// -fsanitize-coverage=trace-pc-guard,pc-table (or linked against a libFuzzer runtime that embeds
+// an equivalent __sancov_pcs section). Unlike the kcov PC stream pkg/cover otherwise consumes,
+// the PC table is a static part of the binary and is always walked in full; FuncEntry marks the
+// entries that correspond to a function's first instrumented edge/guard.
+type SancovPC struct {
+	PC        uint64
+	FuncEntry bool
+}
+
+// sancovPCFlagFuncEntry is bit 0 of the per-entry flags word, per the sancov ABI
+// (compiler-rt/lib/sanitizer_common/sanitizer_coverage_interface.inc PCTableEntry::kFunctionEntry).
+const sancovPCFlagFuncEntry = 1 << 0
+
+// ParseSancovPCTable decodes the contents of a __sancov_pcs/PC-table section into SancovPC
+// entries. The table is a flat array of (pc, flags) pairs, each pointer-sized; ptrSize is 4 or 8
+// depending on the target's word size.
+func ParseSancovPCTable(data []byte, ptrSize int) ([]SancovPC, error) {
+	if ptrSize != 4 && ptrSize != 8 {
+		return nil, fmt.Errorf("sancov: unsupported pointer size %d", ptrSize)
+	}
+	entrySize := 2 * ptrSize
+	if len(data)%entrySize != 0 {
+		return nil, fmt.Errorf("sancov: PC table size %d is not a multiple of the entry size %d", len(data), entrySize)
+	}
+	pcs := make([]SancovPC, 0, len(data)/entrySize)
+	for off := 0; off < len(data); off += entrySize {
+		var pc, flags uint64
+		if ptrSize == 8 {
+			pc = binary.LittleEndian.Uint64(data[off:])
+			flags = binary.LittleEndian.Uint64(data[off+ptrSize:])
+		} else {
+			pc = uint64(binary.LittleEndian.Uint32(data[off:]))
+			flags = uint64(binary.LittleEndian.Uint32(data[off+ptrSize:]))
+		}
+		pcs = append(pcs, SancovPC{
+			PC:        pc,
+			FuncEntry: flags&sancovPCFlagFuncEntry != 0,
+		})
+	}
+	return pcs, nil
+}
+
+// SancovCounters holds the per-PC hit counts dumped alongside a PC table, either from
+// 8-bit-counters instrumentation (one byte per PC, non-zero means hit) or from the
+// __libfuzzer_extra_counters section Go's own runtime now emits. The slice is indexed in the
+// same order as the corresponding ParseSancovPCTable result.
+type SancovCounters []byte
+
+// Hit reports whether the counter at index i recorded at least one execution.
+func (c SancovCounters) Hit(i int) bool {
+	return i < len(c) && c[i] != 0
+}
+
+// Section names compiler-rt's SanitizerCoverage pass emits on ELF targets (see
+// llvm/lib/Transforms/Instrumentation/SanitizerCoverage.cpp); Mach-O uses "__DATA,__sancov_*"
+// instead, which isn't handled here.
+const (
+	sancovPCsSectionName      = "__sancov_pcs"
+	sancovCountersSectionName = "__sancov_cntrs"
+)
+
+// ReadSancovPCTable extracts and parses the PC table that
+// -fsanitize-coverage=trace-pc-guard,pc-table embeds in the named ELF binary.
+func ReadSancovPCTable(bin string) ([]SancovPC, error) {
+	f, err := elf.Open(bin)
+	if err != nil {
+		return nil, fmt.Errorf("sancov: failed to open %v: %w", bin, err)
+	}
+	defer f.Close()
+	data, err := sancovSectionData(f, sancovPCsSectionName)
+	if err != nil {
+		return nil, err
+	}
+	ptrSize := 4
+	if f.Class == elf.ELFCLASS64 {
+		ptrSize = 8
+	}
+	return ParseSancovPCTable(data, ptrSize)
+}
+
+// ReadSancovCounters extracts the 8-bit-counters section dumped alongside the PC table. Its
+// entries line up 1:1 with ReadSancovPCTable's result, in the order compiler-rt emitted them.
+func ReadSancovCounters(bin string) (SancovCounters, error) {
+	f, err := elf.Open(bin)
+	if err != nil {
+		return nil, fmt.Errorf("sancov: failed to open %v: %w", bin, err)
+	}
+	defer f.Close()
+	data, err := sancovSectionData(f, sancovCountersSectionName)
+	if err != nil {
+		return nil, err
+	}
+	return SancovCounters(data), nil
+}
+
+func sancovSectionData(f *elf.File, name string) ([]byte, error) {
+	sec := f.Section(name)
+	if sec == nil {
+		return nil, fmt.Errorf("sancov: no %v section (binary not built with -fsanitize-coverage=...,pc-table"+
+			",inline-8bit-counters?)", name)
+	}
+	data, err := sec.Data()
+	if err != nil {
+		return nil, fmt.Errorf("sancov: failed to read %v: %w", name, err)
+	}
+	return data, nil
+}
+
+// SancovSymbol is a PC table entry correlated against the binary's own symbol table and line
+// info, analogous to the ELF/DWARF symbolization the kcov PC stream already goes through
+// elsewhere in this package.
+type SancovSymbol struct {
+	SancovPC
+	FuncName string
+	File     string
+	Line     int
+}
+
+// CorrelateSancovSymbols resolves each entry of pcs against bin's ELF symbol table (for the
+// enclosing function name) and DWARF line table (for file:line). Entries that don't fall inside
+// any STT_FUNC symbol, or any line table sequence, are returned with FuncName/File left empty.
+func CorrelateSancovSymbols(bin string, pcs []SancovPC) ([]SancovSymbol, error) {
+	f, err := elf.Open(bin)
+	if err != nil {
+		return nil, fmt.Errorf("sancov: failed to open %v: %w", bin, err)
+	}
+	defer f.Close()
+	syms, err := f.Symbols()
+	if err != nil {
+		return nil, fmt.Errorf("sancov: failed to read symbols from %v: %w", bin, err)
+	}
+	dwarfData, err := f.DWARF()
+	if err != nil {
+		return nil, fmt.Errorf("sancov: failed to read DWARF from %v: %w", bin, err)
+	}
+	out := make([]SancovSymbol, len(pcs))
+	for i, p := range pcs {
+		out[i].SancovPC = p
+		out[i].FuncName = funcSymbolForPC(syms, p.PC)
+		out[i].File, out[i].Line = lineForPC(dwarfData, p.PC)
+	}
+	return out, nil
+}
+
+func funcSymbolForPC(syms []elf.Symbol, pc uint64) string {
+	for _, s := range syms {
+		if elf.ST_TYPE(s.Info) != elf.STT_FUNC {
+			continue
+		}
+		if pc >= s.Value && pc < s.Value+s.Size {
+			return s.Name
+		}
+	}
+	return ""
+}
+
+// lineForPC walks every compile unit's line table looking for the row whose address range
+// covers pc, in the same way addr2line resolves a single PC.
+func lineForPC(data *dwarf.Data, pc uint64) (string, int) {
+	r := data.Reader()
+	for {
+		entry, err := r.Next()
+		if err != nil || entry == nil {
+			break
+		}
+		if entry.Tag != dwarf.TagCompileUnit {
+			continue
+		}
+		if file, line, ok := lineForPCInUnit(data, entry, pc); ok {
+			return file, line
+		}
+	}
+	return "", 0
+}
+
+func lineForPCInUnit(data *dwarf.Data, unit *dwarf.Entry, pc uint64) (string, int, bool) {
+	lr, err := data.LineReader(unit)
+	if err != nil || lr == nil {
+		return "", 0, false
+	}
+	var entry, prev dwarf.LineEntry
+	havePrev := false
+	for lr.Next(&entry) == nil {
+		if havePrev && pc >= prev.Address && pc < entry.Address {
+			return prev.File.Name, prev.Line, true
+		}
+		if entry.EndSequence {
+			havePrev = false
+			continue
+		}
+		prev = entry
+		havePrev = true
+	}
+	return "", 0, false
+}
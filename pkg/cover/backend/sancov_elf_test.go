@@ -0,0 +1,86 @@
+// Copyright 2026 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+//go:build linux
+
+package backend
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSancovEndToEnd is the sancov analogue of the kcov good/good-pie matrix in
+// pkg/cover/report_test.go: for each build variant below it compiles a real binary with
+// -fsanitize-coverage=trace-pc-guard,pc-table,inline-8bit-counters, runs it so the counters
+// section records a hit, and checks that the PC table and counters extracted from the ELF
+// binary correlate back to the main function via ELF/DWARF symbolization.
+func TestSancovEndToEnd(t *testing.T) {
+	cc := "clang"
+	if _, err := exec.LookPath(cc); err != nil {
+		t.Skip("clang not found")
+	}
+
+	tests := []struct {
+		name  string
+		flags []string
+	}{
+		{"good", nil},
+		{"good-pie", []string{"-fPIE", "-pie"}},
+		{"good-static", []string{"-static"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testSancovEndToEnd(t, cc, test.flags)
+		})
+	}
+}
+
+func testSancovEndToEnd(t *testing.T, cc string, extraFlags []string) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.c")
+	bin := filepath.Join(dir, "main")
+	if err := os.WriteFile(src, []byte("int main() { return 0; }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	args := append([]string{"-g", "-O0",
+		"-fsanitize-coverage=trace-pc-guard,pc-table,inline-8bit-counters"}, extraFlags...)
+	args = append(args, "-o", bin, src)
+	cmd := exec.Command(cc, args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if strings.Contains(string(out), "unrecognized") {
+			t.Skipf("compiler doesn't support trace-pc-guard,pc-table or %v: %s", extraFlags, out)
+		}
+		t.Fatalf("failed to build test binary: %v\n%s", err, out)
+	}
+
+	if err := exec.Command(bin).Run(); err != nil {
+		t.Fatalf("failed to run test binary: %v", err)
+	}
+
+	pcs, err := ReadSancovPCTable(bin)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, pcs)
+
+	counters, err := ReadSancovCounters(bin)
+	assert.NoError(t, err)
+	assert.Equal(t, len(pcs), len(counters))
+
+	symbols, err := CorrelateSancovSymbols(bin, pcs)
+	assert.NoError(t, err)
+
+	foundMain := false
+	for i, sym := range symbols {
+		if sym.FuncName == "main" {
+			foundMain = true
+			assert.True(t, counters.Hit(i), "main's PC-table entry wasn't recorded as hit")
+		}
+	}
+	assert.True(t, foundMain, "main not found among the correlated sancov symbols")
+}
@@ -0,0 +1,41 @@
+// Copyright 2026 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSancovPCTable(t *testing.T) {
+	// Two entries: a function entry at 0x1000, and a regular edge at 0x1008.
+	data := make([]byte, 4*8)
+	binary.LittleEndian.PutUint64(data[0:], 0x1000)
+	binary.LittleEndian.PutUint64(data[8:], sancovPCFlagFuncEntry)
+	binary.LittleEndian.PutUint64(data[16:], 0x1008)
+	binary.LittleEndian.PutUint64(data[24:], 0)
+
+	pcs, err := ParseSancovPCTable(data, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, []SancovPC{
+		{PC: 0x1000, FuncEntry: true},
+		{PC: 0x1008, FuncEntry: false},
+	}, pcs)
+}
+
+func TestParseSancovPCTableBadSize(t *testing.T) {
+	_, err := ParseSancovPCTable(make([]byte, 7), 8)
+	assert.Error(t, err)
+}
+
+func TestSancovCountersHit(t *testing.T) {
+	c := SancovCounters{0, 1, 0, 5}
+	assert.False(t, c.Hit(0))
+	assert.True(t, c.Hit(1))
+	assert.False(t, c.Hit(2))
+	assert.True(t, c.Hit(3))
+	assert.False(t, c.Hit(4))
+}
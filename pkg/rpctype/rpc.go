@@ -4,19 +4,25 @@
 package rpctype
 
 import (
+	"bufio"
 	"compress/flate"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"net"
 	"net/rpc"
+	"sync/atomic"
 	"time"
 
+	"github.com/klauspost/compress/zstd"
+
 	"github.com/google/syzkaller/pkg/log"
 )
 
 type RPCServer struct {
-	ln net.Listener
-	s  *rpc.Server
+	ln    net.Listener
+	s     *rpc.Server
+	codec atomic.Uint32 // codecID negotiated on the most recently accepted connection, for tests.
 }
 
 func NewRPCServer(addr, name string, receiver interface{}) (*RPCServer, error) {
@@ -43,7 +49,14 @@ func (serv *RPCServer) Serve() {
 			continue
 		}
 		setupKeepAlive(conn, time.Minute)
-		go serv.s.ServeConn(newFlateConn(conn))
+		wrapped, chosen, err := acceptCodec(conn)
+		if err != nil {
+			log.Logf(0, "failed to negotiate rpc codec with %v: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			continue
+		}
+		serv.codec.Store(uint32(chosen))
+		go serv.s.ServeConn(wrapped)
 	}
 }
 
@@ -51,6 +64,12 @@ func (serv *RPCServer) Addr() net.Addr {
 	return serv.ln.Addr()
 }
 
+// Codec returns the transport codec negotiated on the most recently accepted connection.
+// It exists for tests; with multiple concurrent connections it only reflects the latest one.
+func (serv *RPCServer) Codec() string {
+	return codecID(serv.codec.Load()).String()
+}
+
 type RPCClient struct {
 	conn net.Conn
 	c    *rpc.Client
@@ -62,13 +81,37 @@ func NewRPCClient(addr string) (*RPCClient, error) {
 		return nil, err
 	}
 	setupKeepAlive(conn, time.Minute)
+	conn, wrapped, err := dialNegotiatedOrLegacy(addr, conn)
+	if err != nil {
+		return nil, err
+	}
 	cli := &RPCClient{
 		conn: conn,
-		c:    rpc.NewClient(newFlateConn(conn)),
+		c:    rpc.NewClient(wrapped),
 	}
 	return cli, nil
 }
 
+// dialNegotiatedOrLegacy tries the codec handshake on conn, and falls back to a fresh,
+// un-negotiated flate connection if the peer never answers it (most likely because it predates
+// codec negotiation and is waiting to see a flate stream from byte 0, which the handshake bytes
+// already written to conn have irrecoverably corrupted for that purpose). It returns the
+// connection actually in use, which is a new one in the fallback case.
+func dialNegotiatedOrLegacy(addr string, conn net.Conn) (net.Conn, io.ReadWriteCloser, error) {
+	wrapped, _, err := dialCodec(conn)
+	if err == nil {
+		return conn, wrapped, nil
+	}
+	conn.Close()
+	legacyConn, dialErr := net.DialTimeout("tcp", addr, 3*time.Minute)
+	if dialErr != nil {
+		return nil, nil, fmt.Errorf("failed to negotiate rpc codec (%v), and failed to redial for the legacy fallback: %w",
+			err, dialErr)
+	}
+	setupKeepAlive(legacyConn, time.Minute)
+	return legacyConn, newFlateConn(legacyConn), nil
+}
+
 func (cli *RPCClient) Call(method string, args, reply interface{}) error {
 	// Note: SetDeadline is not implemented on fuchsia, so don't fail on error.
 	cli.conn.SetDeadline(time.Now().Add(10 * time.Minute))
@@ -85,7 +128,180 @@ func setupKeepAlive(conn net.Conn, keepAlive time.Duration) {
 	conn.(*net.TCPConn).SetKeepAlivePeriod(keepAlive)
 }
 
-// flateConn wraps net.Conn in flate.Reader/Writer for compressed traffic.
+// codecID identifies one of the wire codecs RPCServer/RPCClient can negotiate for a connection.
+type codecID byte
+
+const (
+	codecNone codecID = iota
+	codecFlate
+	codecZstd
+)
+
+func (id codecID) String() string {
+	switch id {
+	case codecNone:
+		return "none"
+	case codecFlate:
+		return "flate"
+	case codecZstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(id))
+	}
+}
+
+// codec is the pluggable transport compression interface: adding a new codec means implementing
+// this and registering it in codecsByID, not editing the negotiation logic itself.
+type codec interface {
+	Wrap(conn io.ReadWriteCloser) io.ReadWriteCloser
+}
+
+type noneCodec struct{}
+
+func (noneCodec) Wrap(conn io.ReadWriteCloser) io.ReadWriteCloser { return conn }
+
+type flateCodec struct{}
+
+func (flateCodec) Wrap(conn io.ReadWriteCloser) io.ReadWriteCloser { return newFlateConn(conn) }
+
+type zstdCodecImpl struct{}
+
+func (zstdCodecImpl) Wrap(conn io.ReadWriteCloser) io.ReadWriteCloser {
+	wrapped, err := newZstdConn(conn)
+	if err != nil {
+		panic(err)
+	}
+	return wrapped
+}
+
+var codecsByID = map[codecID]codec{
+	codecNone:  noneCodec{},
+	codecFlate: flateCodec{},
+	codecZstd:  zstdCodecImpl{},
+}
+
+// codecPreference lists the codecs a client offers during the handshake, from most to least
+// preferred. The server picks the first one it also supports.
+var codecPreference = []codecID{codecZstd, codecFlate, codecNone}
+
+func wrapCodec(id codecID, conn io.ReadWriteCloser) (io.ReadWriteCloser, error) {
+	c, ok := codecsByID[id]
+	if !ok {
+		return nil, fmt.Errorf("rpctype: unknown codec id %d", id)
+	}
+	return c.Wrap(conn), nil
+}
+
+// codecMagic prefixes the codec handshake a client sends right after dialing. Peers built before
+// codec negotiation was introduced never send it and go straight into flate-compressed rpc
+// traffic, which is how acceptCodec tells the two cases apart.
+var codecMagic = [4]byte{'s', 'y', 'z', 1}
+
+// handshakeTimeout bounds how long the server waits to see codecMagic before assuming the peer
+// predates codec negotiation and falling back to flate. Variable (not const) so tests can shrink it.
+var handshakeTimeout = 5 * time.Second
+
+// handshakeReplyTimeout bounds how long the client waits for the server's chosen-codec reply
+// before assuming the server predates codec negotiation. Variable (not const) so tests can
+// shrink it.
+var handshakeReplyTimeout = 5 * time.Second
+
+// dialCodec sends the client's codec handshake over conn and wraps conn with whichever codec the
+// server chose. It does not fall back on its own; see dialNegotiatedOrLegacy for that.
+func dialCodec(conn net.Conn) (io.ReadWriteCloser, codecID, error) {
+	offer := make([]byte, 0, len(codecMagic)+1+len(codecPreference))
+	offer = append(offer, codecMagic[:]...)
+	offer = append(offer, byte(len(codecPreference)))
+	for _, id := range codecPreference {
+		offer = append(offer, byte(id))
+	}
+	if _, err := conn.Write(offer); err != nil {
+		return nil, 0, fmt.Errorf("failed to send codec handshake: %w", err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(handshakeReplyTimeout)); err != nil {
+		return nil, 0, err
+	}
+	var reply [1]byte
+	_, err := io.ReadFull(conn, reply[:])
+	if deadlineErr := conn.SetReadDeadline(time.Time{}); deadlineErr != nil && err == nil {
+		err = deadlineErr
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read codec handshake reply: %w", err)
+	}
+	chosen := codecID(reply[0])
+	wrapped, err := wrapCodec(chosen, conn)
+	if err != nil {
+		return nil, 0, err
+	}
+	return wrapped, chosen, nil
+}
+
+// acceptCodec reads (and, for modern peers, responds to) the codec handshake on a freshly
+// accepted connection, and returns conn wrapped with the negotiated codec.
+func acceptCodec(conn net.Conn) (io.ReadWriteCloser, codecID, error) {
+	br := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	peek, err := br.Peek(len(codecMagic))
+	conn.SetReadDeadline(time.Time{})
+	// peekedConn re-delivers whatever acceptCodec already consumed from conn via br before
+	// any codec wrapping happens, whether or not a handshake was actually present.
+	peeked := &peekedConn{ReadWriteCloser: conn, r: br}
+	if err != nil || [4]byte(peek) != codecMagic {
+		// No handshake within the timeout: treat conn as a legacy peer that spoke flate
+		// unconditionally, and don't consume anything we peeked. Deliberately don't write a
+		// reply byte here: a genuinely legacy peer isn't expecting one, and writing one would
+		// corrupt its flate stream instead of falling back to it.
+		wrapped, wrapErr := wrapCodec(codecFlate, peeked)
+		return wrapped, codecFlate, wrapErr
+	}
+	if _, err := io.ReadFull(br, make([]byte, len(codecMagic))); err != nil {
+		return nil, 0, fmt.Errorf("failed to read codec magic: %w", err)
+	}
+	var n uint8
+	if err := binary.Read(br, binary.LittleEndian, &n); err != nil {
+		return nil, 0, fmt.Errorf("failed to read codec count: %w", err)
+	}
+	offered := make([]byte, n)
+	if _, err := io.ReadFull(br, offered); err != nil {
+		return nil, 0, fmt.Errorf("failed to read offered codecs: %w", err)
+	}
+	chosen := pickCodec(offered)
+	if _, err := conn.Write([]byte{byte(chosen)}); err != nil {
+		return nil, 0, fmt.Errorf("failed to send chosen codec: %w", err)
+	}
+	wrapped, err := wrapCodec(chosen, peeked)
+	if err != nil {
+		return nil, 0, err
+	}
+	return wrapped, chosen, nil
+}
+
+// pickCodec returns the most preferred codec present in offered, or codecNone if none of the
+// offered IDs are recognized.
+func pickCodec(offered []byte) codecID {
+	for _, pref := range codecPreference {
+		for _, id := range offered {
+			if codecID(id) == pref {
+				return pref
+			}
+		}
+	}
+	return codecNone
+}
+
+// peekedConn is an io.ReadWriteCloser whose Read is served by a bufio.Reader that may already hold bytes
+// consumed (but not discarded) while probing for codecMagic.
+type peekedConn struct {
+	io.ReadWriteCloser
+	r *bufio.Reader
+}
+
+func (p *peekedConn) Read(data []byte) (int, error) {
+	return p.r.Read(data)
+}
+
+// flateConn wraps an io.ReadWriteCloser in flate.Reader/Writer for compressed traffic.
 type flateConn struct {
 	r io.ReadCloser
 	w *flate.Writer
@@ -132,3 +348,48 @@ func (fc *flateConn) Close() error {
 	}
 	return err0
 }
+
+// zstdConn wraps an io.ReadWriteCloser in a zstd stream, flushing after every write so that each
+// RPC message is delivered as soon as it's written, same as flateConn.
+type zstdConn struct {
+	r *zstd.Decoder
+	w *zstd.Encoder
+	c io.Closer
+}
+
+func newZstdConn(conn io.ReadWriteCloser) (io.ReadWriteCloser, error) {
+	r, err := zstd.NewReader(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd reader: %w", err)
+	}
+	w, err := zstd.NewWriter(conn, zstd.WithEncoderLevel(zstd.SpeedDefault))
+	if err != nil {
+		r.Close()
+		return nil, fmt.Errorf("failed to create zstd writer: %w", err)
+	}
+	return &zstdConn{r: r, w: w, c: conn}, nil
+}
+
+func (zc *zstdConn) Read(data []byte) (int, error) {
+	return zc.r.Read(data)
+}
+
+func (zc *zstdConn) Write(data []byte) (int, error) {
+	n, err := zc.w.Write(data)
+	if err != nil {
+		return n, err
+	}
+	if err := zc.w.Flush(); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+func (zc *zstdConn) Close() error {
+	zc.r.Close()
+	err0 := zc.w.Close()
+	if err := zc.c.Close(); err != nil {
+		err0 = err
+	}
+	return err0
+}
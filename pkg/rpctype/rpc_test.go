@@ -0,0 +1,204 @@
+// Copyright 2026 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package rpctype
+
+import (
+	"io"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodecNegotiationPrefersZstd(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	serverErr := make(chan error, 1)
+	var serverConn io.ReadWriteCloser
+	var serverCodec codecID
+	go func() {
+		var err error
+		serverConn, serverCodec, err = acceptCodec(server)
+		serverErr <- err
+	}()
+
+	clientConn, clientCodec, err := dialCodec(client)
+	assert.NoError(t, err)
+	assert.NoError(t, <-serverErr)
+	assert.Equal(t, codecZstd, clientCodec)
+	assert.Equal(t, codecZstd, serverCodec)
+
+	want := []byte("hello from the client")
+	go func() {
+		clientConn.Write(want)
+	}()
+	got := make([]byte, len(want))
+	_, err = io.ReadFull(serverConn, got)
+	assert.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+func TestCodecNegotiationLegacyPeer(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	serverErr := make(chan error, 1)
+	var serverConn io.ReadWriteCloser
+	var serverCodec codecID
+	go func() {
+		var err error
+		serverConn, serverCodec, err = acceptCodec(server)
+		serverErr <- err
+	}()
+
+	// A pre-negotiation peer writes flate-compressed data straight away, without a handshake.
+	legacyConn := newFlateConn(client)
+	want := []byte("hello from a legacy client")
+	go func() {
+		legacyConn.Write(want)
+	}()
+
+	assert.NoError(t, <-serverErr)
+	assert.Equal(t, codecFlate, serverCodec)
+	got := make([]byte, len(want))
+	_, err := io.ReadFull(serverConn, got)
+	assert.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+// TestDialCodecGivesUpAgainstDeadPeer checks that dialCodec doesn't block forever when the peer
+// never answers the handshake, which used to happen because NewRPCClient's read of the reply
+// byte had no deadline.
+func TestDialCodecGivesUpAgainstDeadPeer(t *testing.T) {
+	orig := handshakeReplyTimeout
+	handshakeReplyTimeout = 50 * time.Millisecond
+	defer func() { handshakeReplyTimeout = orig }()
+
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	go io.Copy(io.Discard, server) // never writes back a chosen-codec reply.
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := dialCodec(client)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("dialCodec blocked instead of giving up on the handshake reply")
+	}
+}
+
+// TestNewRPCClientFallsBackToLegacyServer exercises the rolling-upgrade scenario the handshake
+// exists for: a new client dialing a server that predates codec negotiation must still end up
+// talking to it over plain flate, instead of hanging forever waiting for a reply that a legacy
+// server will never send.
+func TestNewRPCClientFallsBackToLegacyServer(t *testing.T) {
+	orig := handshakeReplyTimeout
+	handshakeReplyTimeout = 200 * time.Millisecond
+	defer func() { handshakeReplyTimeout = orig }()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	s := rpc.NewServer()
+	assert.NoError(t, s.RegisterName("Legacy", new(legacyReceiver)))
+	go func() {
+		// The client's first connection carries handshake bytes a legacy server doesn't
+		// understand; closing it immediately stands in for it never producing a reply.
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn.Close()
+		// The client's second, fallback connection is exactly what a pre-negotiation
+		// RPCServer.Serve used to do: wrap in flate and serve, no handshake at all.
+		conn, err = ln.Accept()
+		if err != nil {
+			return
+		}
+		s.ServeConn(newFlateConn(conn))
+	}()
+
+	cli, err := NewRPCClient(ln.Addr().String())
+	assert.NoError(t, err)
+	defer cli.Close()
+
+	var reply int
+	assert.NoError(t, cli.Call("Legacy.Double", 21, &reply))
+	assert.Equal(t, 42, reply)
+}
+
+type legacyReceiver struct{}
+
+func (legacyReceiver) Double(arg int, reply *int) error {
+	*reply = arg * 2
+	return nil
+}
+
+func TestPickCodec(t *testing.T) {
+	assert.Equal(t, codecZstd, pickCodec([]byte{byte(codecNone), byte(codecFlate), byte(codecZstd)}))
+	assert.Equal(t, codecFlate, pickCodec([]byte{byte(codecNone), byte(codecFlate)}))
+	assert.Equal(t, codecNone, pickCodec([]byte{byte(codecNone)}))
+	assert.Equal(t, codecNone, pickCodec(nil))
+}
+
+// payload is a realistic stand-in for the corpus/coverage blobs shipped between syz-manager and
+// syz-fuzzer: mostly-unique bytes with some repeated structure, which compresses but not trivially.
+func payload(n int) []byte {
+	buf := make([]byte, n)
+	for i := range buf {
+		buf[i] = byte(i%251) ^ byte(i/251)
+	}
+	return buf
+}
+
+func benchmarkCodec(b *testing.B, wrap func(io.ReadWriteCloser) io.ReadWriteCloser) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	serverConn := wrap(server)
+	clientConn := wrap(client)
+
+	data := payload(64 * 1024)
+	go func() {
+		sink := make([]byte, len(data))
+		for {
+			if _, err := io.ReadFull(serverConn, sink); err != nil {
+				return
+			}
+		}
+	}()
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := clientConn.Write(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFlateCodec(b *testing.B) {
+	benchmarkCodec(b, newFlateConn)
+}
+
+func BenchmarkZstdCodec(b *testing.B) {
+	benchmarkCodec(b, func(conn io.ReadWriteCloser) io.ReadWriteCloser {
+		wrapped, err := newZstdConn(conn)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return wrapped
+	})
+}